@@ -0,0 +1,88 @@
+// Copyright © 2017 Microsoft <wastore@microsoft.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+var completionCmd = &cobra.Command{
+	Use:   "completion [bash|zsh|fish|powershell]",
+	Short: "Generate a shell completion script for azcopy.",
+	Long: `Generate a shell completion script for azcopy.
+
+The script is written to stdout, so it's typically loaded straight into the running shell, e.g.:
+
+  source <(azcopy completion bash)
+  azcopy completion zsh > "${fpath[1]}/_azcopy"
+  azcopy completion fish | source
+`,
+	DisableFlagsInUseLine: true,
+	ValidArgs:             []string{"bash", "zsh", "fish", "powershell"},
+	Args:                  cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+	// completion is expected to run from a shell rc file (see the Long text above), so it must not
+	// inherit rootCmd's PersistentPreRunE: that starts the whole STE (ste.MainSTE) and kicks off the
+	// version-check network call, which can block a brand new shell's startup for up to 8 seconds
+	// just to print a static script. Explicitly set skipVersionCheck so Execute's post-run fallback
+	// (for commands, like this one, that return instead of calling glcm.Exit) doesn't start it either.
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		skipVersionCheck = true
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		switch args[0] {
+		case "bash":
+			return rootCmd.GenBashCompletionV2(os.Stdout, true)
+		case "zsh":
+			return rootCmd.GenZshCompletion(os.Stdout)
+		case "fish":
+			return rootCmd.GenFishCompletion(os.Stdout, true)
+		case "powershell":
+			return rootCmd.GenPowerShellCompletionWithDesc(os.Stdout)
+		default:
+			// unreachable: cobra.OnlyValidArgs already rejected anything else
+			return fmt.Errorf("unsupported shell %q", args[0])
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(completionCmd)
+}
+
+// registerFlagValueCompletion wires up static completion for a flag whose legal values are a
+// fixed, small set, so shells can offer them without azcopy needing to do anything smarter than
+// report the list back. Call it right after defining the flag it completes (see root.go's init()
+// for --output-type): RegisterFlagCompletionFunc requires the flag to already exist, and Go runs
+// a package's init() funcs in file-name order, so registering from a different file's init() can
+// run before the flag itself has been defined.
+func registerFlagValueCompletion(cmd *cobra.Command, flagName string, values ...string) {
+	err := cmd.RegisterFlagCompletionFunc(flagName, func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return values, cobra.ShellCompDirectiveNoFileComp
+	})
+	if err != nil {
+		// only happens if flagName doesn't exist on cmd, which is a programming error
+		panic(err)
+	}
+}
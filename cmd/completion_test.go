@@ -0,0 +1,116 @@
+// Copyright © 2017 Microsoft <wastore@microsoft.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"testing"
+)
+
+// TestCompletionScriptsAreSyntacticallyValid generates each supported shell's completion script
+// and, where the shell is available on the test machine, shells out to its syntax-check mode
+// (bash -n / zsh -n) to catch a regression in cobra's generator or in our command tree (e.g. a
+// flag name with characters that break the generated script) before it reaches a user's shell.
+func TestCompletionScriptsAreSyntacticallyValid(t *testing.T) {
+	testCases := []struct {
+		shell        string
+		generate     func() (string, error)
+		syntaxCheck  string // shell binary, invoked as `<syntaxCheck> -n <scriptPath>`
+		skipIfNoTool bool
+	}{
+		{shell: "bash", generate: generateCompletionScript("bash"), syntaxCheck: "bash", skipIfNoTool: true},
+		{shell: "zsh", generate: generateCompletionScript("zsh"), syntaxCheck: "zsh", skipIfNoTool: true},
+		// fish and powershell don't expose a simple "parse only" mode we can shell out to here,
+		// so we just make sure generation itself succeeds and produces non-empty output.
+		{shell: "fish", generate: generateCompletionScript("fish")},
+		{shell: "powershell", generate: generateCompletionScript("powershell")},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.shell, func(t *testing.T) {
+			script, err := tc.generate()
+			if err != nil {
+				t.Fatalf("generating %s completion: %v", tc.shell, err)
+			}
+			if len(script) == 0 {
+				t.Fatalf("%s completion script was empty", tc.shell)
+			}
+
+			if tc.syntaxCheck == "" {
+				return
+			}
+
+			if _, err := exec.LookPath(tc.syntaxCheck); err != nil {
+				if tc.skipIfNoTool {
+					t.Skipf("%s not installed, skipping syntax check", tc.syntaxCheck)
+				}
+				t.Fatalf("%s not installed: %v", tc.syntaxCheck, err)
+			}
+
+			scriptPath := writeTempScript(t, tc.shell, script)
+			cmd := exec.Command(tc.syntaxCheck, "-n", scriptPath)
+			var stderr bytes.Buffer
+			cmd.Stderr = &stderr
+			if err := cmd.Run(); err != nil {
+				t.Fatalf("%s -n rejected the generated %s completion script: %v\n%s", tc.syntaxCheck, tc.shell, err, stderr.String())
+			}
+		})
+	}
+}
+
+func generateCompletionScript(shell string) func() (string, error) {
+	return func() (string, error) {
+		var buf bytes.Buffer
+		var err error
+		switch shell {
+		case "bash":
+			err = rootCmd.GenBashCompletionV2(&buf, true)
+		case "zsh":
+			err = rootCmd.GenZshCompletion(&buf)
+		case "fish":
+			err = rootCmd.GenFishCompletion(&buf, true)
+		case "powershell":
+			err = rootCmd.GenPowerShellCompletionWithDesc(&buf)
+		}
+		return buf.String(), err
+	}
+}
+
+func writeTempScript(t *testing.T, shell, contents string) string {
+	t.Helper()
+	f, err := os.CreateTemp("", "azcopy-completion-"+shell+"-*.sh")
+	if err != nil {
+		t.Fatalf("creating temp file: %v", err)
+	}
+	t.Cleanup(func() { os.Remove(f.Name()) })
+
+	if _, err := f.WriteString(contents); err != nil {
+		t.Fatalf("writing temp file: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("closing temp file: %v", err)
+	}
+
+	return f.Name()
+}
@@ -23,17 +23,26 @@ package cmd
 import (
 	"bytes"
 	"context"
+	"encoding/json"
+	"fmt"
 	"net/url"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/Azure/azure-storage-azcopy/common"
+	"github.com/Azure/azure-storage-azcopy/common/pipeline"
 	"github.com/Azure/azure-storage-azcopy/ste"
 	"github.com/Azure/azure-storage-blob-go/azblob"
 	"github.com/spf13/cobra"
 )
 
+// defaultVersionMetadataUrl is used when --version-check-source / AZCOPY_VERSION_CHECK_SOURCE
+// aren't set; it's the same aka.ms blob AzCopy has always checked against.
+const defaultVersionMetadataUrl = "https://aka.ms/azcopyv10-version-metadata"
+
 var azcopyAppPathFolder string
 var azcopyLogPathFolder string
 var azcopyJobPlanFolder string
@@ -42,6 +51,20 @@ var outputFormatRaw string
 var cancelFromStdin bool
 var azcopyOutputFormat common.OutputFormat
 var cmdLineCapMegaBitsPerSecond uint32
+var skipVersionCheckFlag bool
+var versionCheckSourceFlag string
+var useAzblobV2PipelineFlag bool
+var requireSignedVersionCheckFlag bool
+
+// resolved once, in PersistentPreRunE (or in Execute, for commands that don't run it), by
+// combining the flag, the environment variable and the config file; Execute reads it after
+// rootCmd.Execute() returns to decide whether it's safe to wait on beginDetectNewVersion's
+// completion channel
+var skipVersionCheck bool
+
+// resolved once, in PersistentPreRunE, from --version-check-source / AZCOPY_VERSION_CHECK_SOURCE;
+// Execute reuses it so the source is only computed once per invocation
+var versionMetadataSource common.VersionMetadataSource
 
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
@@ -62,18 +85,36 @@ var rootCmd = &cobra.Command{
 		providePerformanceAdvice := cmd == benchCmd
 
 		// startup of the STE happens here, so that the startup can access the values of command line parameters that are defined for "root" command
-		concurrencySettings := ste.NewConcurrencySettings(azcopyMaxFileAndSocketHandles, preferToAutoTuneGRs)
+		concurrencySettings := ste.NewConcurrencySettings(azcopyMaxFileAndSocketHandles, preferToAutoTuneGRs, azcopyJobPlanFolder)
 		err = ste.MainSTE(concurrencySettings, int64(cmdLineCapMegaBitsPerSecond), azcopyJobPlanFolder, azcopyLogPathFolder, providePerformanceAdvice)
 		if err != nil {
 			return err
 		}
 
+		if concurrencySettings.AutoTune {
+			// NewAutoTuner persists every adjustment it makes to azcopyJobPlanFolder (see
+			// ste.ConcurrencyHintFileName), so the next auto-tuned job reusing this folder starts
+			// from where this one left off instead of from the CPU-derived default. MainSTE doesn't
+			// start the hill-climb loop itself yet (see ste.ConcurrencySettings's doc comment for
+			// why), so the only decision to report here is the starting point.
+			autoTuner := ste.NewAutoTuner(concurrencySettings, azcopyJobPlanFolder)
+			if providePerformanceAdvice {
+				glcm.Info(fmt.Sprintf("concurrency auto-tune: starting at %d goroutines (%s)", autoTuner.Concurrency(), autoTuner.Reason()))
+			}
+		}
+
+		skipVersionCheck = resolveSkipVersionCheck(cmd)
+		if skipVersionCheck {
+			return nil
+		}
+		versionMetadataSource = resolveVersionMetadataSource(cmd)
+
 		// spawn a routine to fetch and compare the local application's version against the latest version available
 		// if there's a newer version that can be used, then write the suggestion to stderr
 		// however if this takes too long the message won't get printed
 		// Note: this function is neccessary for non-help, non-login commands, since they don't reach the corresponding
 		// beginDetectNewVersion call in Execute (below)
-		beginDetectNewVersion()
+		beginDetectNewVersion(versionMetadataSource)
 
 		return nil
 	},
@@ -95,11 +136,26 @@ func Execute(azsAppPathFolder, logPathFolder string, jobPlanFolder string, maxFi
 	} else {
 		// our commands all control their own life explicitly with the lifecycle manager
 		// only commands that don't explicitly exit actually reach this point (e.g. help commands and login commands)
-		select {
-		case <-beginDetectNewVersion():
-			// noop
-		case <-time.After(time.Second * 8):
-			// don't wait too long
+		if !skipVersionCheck && versionMetadataSource == nil {
+			// rootCmd's PersistentPreRunE didn't run (e.g. --help), so the flag, environment variable
+			// and config file haven't been consulted yet
+			skipVersionCheck = resolveSkipVersionCheck(rootCmd)
+		}
+		if !skipVersionCheck {
+			// versionMetadataSource is only set once rootCmd's real PersistentPreRunE has run; for
+			// commands that reach this point without it having run (e.g. --help), fall back to
+			// resolving it here rather than handing beginDetectNewVersion a nil source
+			source := versionMetadataSource
+			if source == nil {
+				source = resolveVersionMetadataSource(rootCmd)
+			}
+
+			select {
+			case <-beginDetectNewVersion(source):
+				// noop
+			case <-time.After(time.Second * 8):
+				// don't wait too long
+			}
 		}
 		glcm.Exit(nil, common.EExitCode.Success())
 	}
@@ -111,23 +167,221 @@ func init() {
 
 	rootCmd.PersistentFlags().Uint32Var(&cmdLineCapMegaBitsPerSecond, "cap-mbps", 0, "Caps the transfer rate, in megabits per second. Moment-by-moment throughput might vary slightly from the cap. If this option is set to zero, or it is omitted, the throughput isn't capped.")
 	rootCmd.PersistentFlags().StringVar(&outputFormatRaw, "output-type", "text", "Format of the command's output. The choices include: text, json. The default value is 'text'.")
+	registerFlagValueCompletion(rootCmd, "output-type", "text", "json")
 
 	// Note: this is due to Windows not supporting signals properly
 	rootCmd.PersistentFlags().BoolVar(&cancelFromStdin, "cancel-from-stdin", false, "Used by partner teams to send in `cancel` through stdin to stop a job.")
 
 	// reserved for partner teams
 	rootCmd.PersistentFlags().MarkHidden("cancel-from-stdin")
+
+	rootCmd.PersistentFlags().BoolVar(&skipVersionCheckFlag, "skip-version-check", false, "Do not periodically check for a newer version of AzCopy. Useful in constrained or air-gapped environments where the update-check endpoint is unreachable. Can also be set with the "+skipVersionCheckEnvVar+" environment variable, or the "+skipVersionCheckConfigKey+" key in the AzCopy config file.")
+
+	rootCmd.PersistentFlags().StringVar(&versionCheckSourceFlag, "version-check-source", "", "Where to fetch the version-check manifest from. An https:// URL is read as a signed JSON manifest, a file:// URL is read from local disk, and anything else (including the default) is read as an anonymously-accessible append/page/block blob URL. Can also be set with the "+versionCheckSourceEnvVar+" environment variable.")
+
+	rootCmd.PersistentFlags().BoolVar(&requireSignedVersionCheckFlag, "require-signed-version-check", false, "Reject the version-check manifest unless it's signed, even for a blob --version-check-source (the default aka.ms source doesn't publish a signed manifest yet, so this will make the version check fail there until it does). https:// and file:// sources already require a signature regardless of this flag. Can also be set with the "+requireSignedVersionCheckEnvVar+" environment variable.")
+
+	rootCmd.PersistentFlags().BoolVar(&useAzblobV2PipelineFlag, "use-azblob-v2-pipeline", false, "Use the azcore-based common/pipeline transport instead of the legacy azure-storage-blob-go one. Experimental, and currently only affects the version check: ste's transfer paths (upload/download/copy) don't use common/pipeline yet, so this flag has no effect on them. Can also be set with the "+useAzblobV2PipelineEnvVar+" environment variable.")
+	// this is an in-progress migration seam, not yet a feature end users should need to reach for
+	rootCmd.PersistentFlags().MarkHidden("use-azblob-v2-pipeline")
+}
+
+// useAzblobV2PipelineEnvVar lets the azcore-based transport be opted into without passing
+// --use-azblob-v2-pipeline on every invocation, e.g. while soak-testing the migration.
+const useAzblobV2PipelineEnvVar = "AZCOPY_USE_AZBLOB_V2"
+
+func useAzblobV2Pipeline() bool {
+	if useAzblobV2PipelineFlag {
+		return true
+	}
+	parsed, err := strconv.ParseBool(os.Getenv(useAzblobV2PipelineEnvVar))
+	return err == nil && parsed
+}
+
+// environment variable and config-file key that let the version check be disabled without passing
+// --skip-version-check on every invocation (e.g. from a provisioning script in an air-gapped environment)
+const skipVersionCheckEnvVar = "AZCOPY_SKIP_VERSION_CHECK"
+const skipVersionCheckConfigKey = "skip-version-check"
+
+// azcopyConfig mirrors the small set of persistent settings that live in the AzCopy config file,
+// alongside the OAuth token cache, under azcopyAppPathFolder
+type azcopyConfig struct {
+	SkipVersionCheck bool `json:"skip-version-check"`
+}
+
+func azcopyConfigFilePath() string {
+	if azcopyAppPathFolder == "" {
+		return ""
+	}
+	return filepath.Join(azcopyAppPathFolder, "config.json")
+}
+
+// readSkipVersionCheckFromConfigFile returns the configured value and whether the key was present;
+// a missing file or a missing key are both treated as "not configured" rather than an error, since
+// the config file is optional
+func readSkipVersionCheckFromConfigFile() (value bool, ok bool) {
+	path := azcopyConfigFilePath()
+	if path == "" {
+		return false, false
+	}
+
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return false, false
+	}
+
+	var cfg azcopyConfig
+	if err := json.Unmarshal(contents, &cfg); err != nil {
+		return false, false
+	}
+
+	return cfg.SkipVersionCheck, true
+}
+
+// resolveSkipVersionCheck applies, in order of precedence, the explicit command-line flag, the
+// AZCOPY_SKIP_VERSION_CHECK environment variable, and the config file, so that the version check
+// can be disabled once (e.g. via environment or config) without repeating the flag on every call
+func resolveSkipVersionCheck(cmd *cobra.Command) bool {
+	if cmd.Flags().Changed("skip-version-check") {
+		return skipVersionCheckFlag
+	}
+
+	if raw := os.Getenv(skipVersionCheckEnvVar); raw != "" {
+		if parsed, err := strconv.ParseBool(raw); err == nil {
+			return parsed
+		}
+	}
+
+	if value, ok := readSkipVersionCheckFromConfigFile(); ok {
+		return value
+	}
+
+	return skipVersionCheckFlag
+}
+
+// versionCheckSourceEnvVar lets the version-check manifest location be overridden without passing
+// --version-check-source on every invocation, mirroring skipVersionCheckEnvVar above.
+const versionCheckSourceEnvVar = "AZCOPY_VERSION_CHECK_SOURCE"
+
+// requireSignedVersionCheckEnvVar mirrors --require-signed-version-check, for environments (e.g.
+// enterprise images) that want the stricter behavior baked in without editing every invocation.
+const requireSignedVersionCheckEnvVar = "AZCOPY_REQUIRE_SIGNED_VERSION_CHECK"
+
+// resolveRequireSignedVersionCheck applies flag > env var precedence, matching
+// resolveSkipVersionCheck (minus the config file, which --require-signed-version-check doesn't
+// have a key for yet).
+func resolveRequireSignedVersionCheck(cmd *cobra.Command) bool {
+	if cmd.Flags().Changed("require-signed-version-check") {
+		return requireSignedVersionCheckFlag
+	}
+
+	if raw := os.Getenv(requireSignedVersionCheckEnvVar); raw != "" {
+		if parsed, err := strconv.ParseBool(raw); err == nil {
+			return parsed
+		}
+	}
+
+	return requireSignedVersionCheckFlag
+}
+
+// blobVersionMetadataSource reads the version manifest from an (anonymously-accessible) append,
+// block, or page blob, via the same azblob pipeline AzCopy already builds for anonymous access.
+// It lives here, rather than in the common package, because it depends on cmd's createBlobPipeline.
+type blobVersionMetadataSource struct {
+	URL string
+	// RequireSigned, when true, rejects a legacy unsigned response instead of falling back to it
+	// (see --require-signed-version-check). The default aka.ms source doesn't publish a signed
+	// manifest yet, so leaving this false (the default) is what keeps the default install base
+	// working today; see versionMetadataPublicKeyBase64's doc comment for the security tradeoff
+	// that implies.
+	RequireSigned bool
+}
+
+func (s *blobVersionMetadataSource) FetchVersionMetadata(ctx context.Context) (*common.VersionMetadata, error) {
+	downloader := pipeline.NewDownloader(useAzblobV2Pipeline(), s.legacyDownload)
+	raw, err := downloader.DownloadBlob(ctx, s.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.RequireSigned {
+		return common.ParseAndVerifyVersionMetadata(raw)
+	}
+
+	// the default source (aka.ms/azcopyv10-version-metadata) still serves the legacy, unsigned
+	// first-line-of-text protocol today, so tolerate that here rather than requiring every blob
+	// source to already speak the new signed format; see ParseVersionMetadataOrLegacy's doc comment
+	return common.ParseVersionMetadataOrLegacy(raw)
 }
 
-// always spins up a new goroutine, because sometimes the aka.ms URL can't be reached (e.g. a constrained environment where
-// aka.ms is not resolvable to a reachable IP address). In such cases, this routine will run for ever, and the caller should
-// just give up on it.
+// legacyDownload is the pre-migration download path, built on createBlobPipeline and
+// azure-storage-blob-go. It's kept as-is and handed to pipeline.NewDownloader as the fallback, so
+// --use-azblob-v2-pipeline / AZCOPY_USE_AZBLOB_V2 can switch stacks without duplicating this logic.
+func (s *blobVersionMetadataSource) legacyDownload(ctx context.Context, blobURL string) ([]byte, error) {
+	p, err := createBlobPipeline(ctx, common.CredentialInfo{CredentialType: common.ECredentialType.Anonymous()})
+	if err != nil {
+		return nil, err
+	}
+
+	u, err := url.Parse(blobURL)
+	if err != nil {
+		return nil, err
+	}
+
+	blobURLClient := azblob.NewBlobURL(*u, p)
+	blobStream, err := blobURLClient.Download(ctx, 0, azblob.CountToEnd, azblob.BlobAccessConditions{}, false)
+	if err != nil {
+		return nil, err
+	}
+
+	blobBody := blobStream.Body(azblob.RetryReaderOptions{MaxRetryRequests: ste.MaxRetryPerDownloadBody})
+	defer blobBody.Close()
+
+	buf := new(bytes.Buffer)
+	if _, err := buf.ReadFrom(blobBody); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// resolveVersionMetadataSource picks the source to fetch the version-check manifest from, in the
+// same flag > env var > default order as resolveSkipVersionCheck.
+func resolveVersionMetadataSource(cmd *cobra.Command) common.VersionMetadataSource {
+	raw := versionCheckSourceFlag
+	if !cmd.Flags().Changed("version-check-source") {
+		if fromEnv := os.Getenv(versionCheckSourceEnvVar); fromEnv != "" {
+			raw = fromEnv
+		}
+	}
+
+	requireSigned := resolveRequireSignedVersionCheck(cmd)
+
+	switch {
+	case raw == "":
+		return &blobVersionMetadataSource{URL: defaultVersionMetadataUrl, RequireSigned: requireSigned}
+	case strings.HasPrefix(raw, "https://"):
+		return common.NewHTTPSVersionMetadataSource(raw)
+	case strings.HasPrefix(raw, "file://"):
+		return common.NewFileVersionMetadataSource(strings.TrimPrefix(raw, "file://"))
+	default:
+		// anything else is treated as a (possibly non-default) blob URL, to match pre-existing behavior
+		return &blobVersionMetadataSource{URL: raw, RequireSigned: requireSigned}
+	}
+}
+
+// always spins up a new goroutine, because sometimes the version-check endpoint can't be reached
+// (e.g. a constrained environment where aka.ms is not resolvable to a reachable IP address). In
+// such cases the fetch gives up after 8 seconds, and the returned channel is never closed (it is
+// only closed when the check runs to completion), so the caller should not wait on it unbounded.
 // We spin up the GR here, not in the caller, so that the need to use a separate GC can never be forgotten
 // (if do it synchronously, and can't resolve URL, this blocks caller for ever)
-func beginDetectNewVersion() chan struct{} {
+func beginDetectNewVersion(source common.VersionMetadataSource) chan struct{} {
 	completionChannel := make(chan struct{})
 	go func() {
-		const versionMetadataUrl = "https://aka.ms/azcopyv10-version-metadata"
+		// bound the whole check to the same 8 seconds that Execute is willing to wait for it, so that
+		// an unreachable endpoint (e.g. in an air-gapped environment) can't leak this goroutine forever
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+		defer cancel()
 
 		// step 0: check the Stderr before checking version
 		_, err := os.Stderr.Stat()
@@ -135,43 +389,20 @@ func beginDetectNewVersion() chan struct{} {
 			return
 		}
 
-		// step 1: initialize pipeline
-		p, err := createBlobPipeline(context.TODO(), common.CredentialInfo{CredentialType: common.ECredentialType.Anonymous()})
+		// step 1: fetch and verify the manifest from wherever this install is configured to look;
+		// each source decides its own parsing/verification policy (see VersionMetadataSource's doc
+		// comment), so a badly-signed or malformed manifest is already rejected by this call
+		metadata, err := source.FetchVersionMetadata(ctx)
 		if err != nil {
 			return
 		}
 
-		// step 2: parse source url
-		u, err := url.Parse(versionMetadataUrl)
-		if err != nil {
-			return
-		}
-
-		// step 3: start download
-		blobURL := azblob.NewBlobURL(*u, p)
-		blobStream, err := blobURL.Download(context.TODO(), 0, azblob.CountToEnd, azblob.BlobAccessConditions{}, false)
-		if err != nil {
-			return
-		}
-
-		blobBody := blobStream.Body(azblob.RetryReaderOptions{MaxRetryRequests: ste.MaxRetryPerDownloadBody})
-		defer blobBody.Close()
-
-		// step 4: read newest version str
-		buf := new(bytes.Buffer)
-		n, err := buf.ReadFrom(blobBody)
-		if n == 0 || err != nil {
-			return
-		}
-		// only take the first line, in case the version metadata file is upgraded in the future
-		remoteVersion := strings.Split(buf.String(), "\n")[0]
-
-		// step 5: compare remote version to local version to see if there's a newer AzCopy
+		// step 2: compare remote version to local version to see if there's a newer AzCopy
 		v1, err := NewVersion(common.AzcopyVersion)
 		if err != nil {
 			return
 		}
-		v2, err := NewVersion(remoteVersion)
+		v2, err := NewVersion(metadata.Latest)
 		if err != nil {
 			return
 		}
@@ -181,7 +412,7 @@ func beginDetectNewVersion() chan struct{} {
 			executableName := executablePathSegments[len(executablePathSegments)-1]
 
 			// output in info mode instead of stderr, as it was crashing CI jobs of some people
-			glcm.Info(executableName + ": A newer version " + remoteVersion + " is available to download\n")
+			glcm.Info(executableName + ": A newer version " + metadata.Latest + " is available to download\n")
 		}
 
 		// let caller know we have finished, if they want to know
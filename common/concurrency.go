@@ -0,0 +1,55 @@
+// Copyright © 2017 Microsoft <wastore@microsoft.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package common
+
+// ConcurrencyMinValue and ConcurrencyMaxValue bound the CPU-derived starting concurrency computed
+// by ComputeConcurrencyValue. They're exported so ste's auto-tuner can clamp to the same defaults
+// (or to user-supplied bounds) rather than duplicating the numbers.
+const (
+	ConcurrencyMinValue = 32
+	ConcurrencyMaxValue = 300
+	concurrencyPerCPU   = 16
+)
+
+// ComputeConcurrencyValue picks a default number of concurrent goroutines for a transfer job from
+// the number of CPUs available: flat 32 on weak machines, 16*NumCPU in the middle, and capped at
+// 300 on very large machines. It's deliberately simple and CPU-only; it's meant as a fast, safe
+// starting point for ste's throughput-based auto-tuner (see ste.NewAutoTuner), not a tuned value.
+func ComputeConcurrencyValue(numOfCPUs int) int {
+	return ComputeConcurrencyValueWithBounds(numOfCPUs, ConcurrencyMinValue, ConcurrencyMaxValue)
+}
+
+// ComputeConcurrencyValueWithBounds is ComputeConcurrencyValue with caller-supplied bounds, for
+// the (rare) case where a user has overridden the default min/max, e.g. to stay under a known
+// per-process handle limit.
+func ComputeConcurrencyValueWithBounds(numOfCPUs, minConcurrency, maxConcurrency int) int {
+	concurrencyValue := concurrencyPerCPU * numOfCPUs
+
+	if numOfCPUs <= 4 {
+		concurrencyValue = minConcurrency
+	}
+
+	if concurrencyValue > maxConcurrency {
+		concurrencyValue = maxConcurrency
+	}
+
+	return concurrencyValue
+}
@@ -32,3 +32,20 @@ func (s *mainTestSuite) TestConcurrencyValue(c *chk.C) {
 		c.Assert(v, chk.Equals, maxConcurrency)
 	}
 }
+
+func (s *mainTestSuite) TestConcurrencyValueWithCustomBounds(c *chk.C) {
+	const customMin = 64
+	const customMax = 128
+
+	// weak machines still floor out at the custom minimum, not the default one
+	v := ComputeConcurrencyValueWithBounds(2, customMin, customMax)
+	c.Assert(v, chk.Equals, customMin)
+
+	// moderate machines are still 16*NumCPU, as long as that stays under the custom max
+	v = ComputeConcurrencyValueWithBounds(6, customMin, customMax)
+	c.Assert(v, chk.Equals, 16*6)
+
+	// powerful machines clamp to the custom maximum instead of the default one
+	v = ComputeConcurrencyValueWithBounds(19, customMin, customMax)
+	c.Assert(v, chk.Equals, customMax)
+}
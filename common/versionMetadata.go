@@ -0,0 +1,201 @@
+// Copyright © 2017 Microsoft <wastore@microsoft.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package common
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// versionMetadataPublicKeyBase64 is the Ed25519 public key AzCopy uses to verify the signature on
+// a version manifest before surfacing an upgrade recommendation, for sources that speak the signed
+// protocol (HTTPSVersionMetadataSource, FileVersionMetadataSource). Pinning the key here, rather
+// than trusting whatever those endpoints happen to return, means a compromised or mirrored
+// endpoint can't trick a user into "upgrading" to a malicious build - for those sources.
+//
+// The default source (the aka.ms blob that every install uses unless --version-check-source is
+// set) does NOT get this protection yet: it still serves the legacy, unsigned first-line-of-text
+// protocol, so ParseVersionMetadataOrLegacy accepts it unverified (see that function's doc
+// comment). A compromised or redirected aka.ms can still suggest an arbitrary "latest" version to
+// the default install base today. Closing that gap requires aka.ms to publish a manifest signed
+// with this key and a cutover that stops accepting the unsigned fallback for the default source -
+// neither of which this change does.
+//
+// This particular key is also a freshly generated placeholder, not AzCopy's real release key -
+// signing manifests for a genuine release requires the matching private key and release-pipeline
+// tooling that live outside this repo. Whoever owns the release process needs to swap this
+// constant for the real public key, publish signed manifests with it from aka.ms, and only then
+// remove the legacy fallback for the default source.
+const versionMetadataPublicKeyBase64 = "pFxSWFTeB/XQcFg/QjxQ6wK22ZFVQBX5mw6GTOdiAK4="
+
+// VersionMetadata is the small JSON manifest published alongside each AzCopy release. It replaces
+// the old protocol of treating the first line of a text blob as the latest version string.
+type VersionMetadata struct {
+	Latest       string `json:"latest"`
+	MinSupported string `json:"min_supported"`
+	// Signature is the base64-encoded Ed25519 detached signature of "Latest\nMinSupported",
+	// produced with the private half of versionMetadataPublicKeyBase64.
+	Signature string `json:"signature"`
+}
+
+// signedPayload returns the exact bytes that were signed to produce Signature.
+func (v VersionMetadata) signedPayload() []byte {
+	return []byte(v.Latest + "\n" + v.MinSupported)
+}
+
+// VersionMetadataSource abstracts where AzCopy fetches its version-check manifest from. The
+// default is the publicly hosted aka.ms blob, but enterprises operating in locked-down networks
+// can mirror the manifest internally (HTTPS endpoint or a file dropped by configuration
+// management) and point AzCopy at that instead, via --version-check-source.
+//
+// FetchVersionMetadata is responsible for both fetching and parsing/verifying: each implementation
+// decides how strict to be about that, rather than a single shared caller applying one policy to
+// every source (see ParseVersionMetadataOrLegacy's doc comment for why that distinction matters).
+type VersionMetadataSource interface {
+	FetchVersionMetadata(ctx context.Context) (*VersionMetadata, error)
+}
+
+// HTTPSVersionMetadataSource fetches the manifest from a plain HTTPS JSON endpoint, e.g. one
+// hosted by an enterprise mirror. It's a new, opt-in source, so it always requires a validly
+// signed manifest - unlike the default blob source, it never falls back to the legacy format.
+type HTTPSVersionMetadataSource struct {
+	URL string
+}
+
+func NewHTTPSVersionMetadataSource(url string) *HTTPSVersionMetadataSource {
+	return &HTTPSVersionMetadataSource{URL: url}
+}
+
+func (s *HTTPSVersionMetadataSource) FetchVersionMetadata(ctx context.Context) (*VersionMetadata, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("version metadata endpoint %s returned status %d", s.URL, resp.StatusCode)
+	}
+
+	raw, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseAndVerifyVersionMetadata(raw)
+}
+
+// FileVersionMetadataSource reads the manifest from a local file, for air-gapped environments
+// where it is dropped onto disk out of band (e.g. by configuration management). Like
+// HTTPSVersionMetadataSource, it's a new, opt-in source and always requires a validly signed
+// manifest.
+type FileVersionMetadataSource struct {
+	Path string
+}
+
+func NewFileVersionMetadataSource(path string) *FileVersionMetadataSource {
+	return &FileVersionMetadataSource{Path: path}
+}
+
+func (s *FileVersionMetadataSource) FetchVersionMetadata(ctx context.Context) (*VersionMetadata, error) {
+	raw, err := ioutil.ReadFile(s.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseAndVerifyVersionMetadata(raw)
+}
+
+// ParseAndVerifyVersionMetadata parses raw as a VersionMetadata manifest and verifies its
+// signature against the bundled AzCopy public key. An error is returned if the manifest is
+// malformed, the signature is missing/invalid, or the signature doesn't verify - callers should
+// treat all of these as "couldn't determine the latest version" rather than surfacing a partial
+// result.
+func ParseAndVerifyVersionMetadata(raw []byte) (*VersionMetadata, error) {
+	var metadata VersionMetadata
+	if err := json.Unmarshal(raw, &metadata); err != nil {
+		return nil, fmt.Errorf("malformed version metadata: %w", err)
+	}
+
+	if metadata.Latest == "" {
+		return nil, fmt.Errorf("version metadata is missing the 'latest' field")
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(metadata.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("version metadata signature is not valid base64: %w", err)
+	}
+
+	publicKey, err := base64.StdEncoding.DecodeString(versionMetadataPublicKeyBase64)
+	if err != nil {
+		// this is a bug in AzCopy itself, not something a caller can work around
+		return nil, fmt.Errorf("version metadata public key is not valid base64: %w", err)
+	}
+
+	if !ed25519.Verify(ed25519.PublicKey(publicKey), metadata.signedPayload(), signature) {
+		return nil, fmt.Errorf("version metadata signature verification failed")
+	}
+
+	return &metadata, nil
+}
+
+// ParseVersionMetadataOrLegacy behaves like ParseAndVerifyVersionMetadata, except that if raw
+// isn't JSON at all, it's treated as the legacy, unsigned protocol this replaces (the first line
+// of the response is taken as the latest version, same as AzCopy always did before this manifest
+// format existed) instead of being rejected outright.
+//
+// This exists only so the default --version-check-source (the aka.ms blob, which still serves the
+// legacy format today) keeps working until that endpoint is migrated to publish a signed manifest.
+// It intentionally is NOT used for sources that are expected to already speak the new protocol
+// (HTTPSVersionMetadataSource, FileVersionMetadataSource): falling back to an unsigned response
+// there would let an attacker who controls the endpoint bypass signature verification entirely by
+// simply not sending JSON.
+func ParseVersionMetadataOrLegacy(raw []byte) (*VersionMetadata, error) {
+	if looksLikeJSON(raw) {
+		// it was JSON, just bad: malformed, missing fields, or signature verification failed.
+		// Falling back to the legacy parser here would defeat the point of verifying it at all.
+		return ParseAndVerifyVersionMetadata(raw)
+	}
+
+	latest := strings.TrimSpace(strings.Split(string(raw), "\n")[0])
+	if latest == "" {
+		return nil, fmt.Errorf("legacy version metadata response was empty")
+	}
+
+	return &VersionMetadata{Latest: latest}, nil
+}
+
+func looksLikeJSON(raw []byte) bool {
+	trimmed := bytes.TrimSpace(raw)
+	return len(trimmed) > 0 && trimmed[0] == '{'
+}
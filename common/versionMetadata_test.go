@@ -0,0 +1,143 @@
+// Copyright © 2017 Microsoft <wastore@microsoft.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package common
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	chk "gopkg.in/check.v1"
+)
+
+// Test is the package's entry point into gopkg.in/check.v1: without it, `go test` runs zero of
+// the chk.Suite tests below (or in concurrency_test.go) and reports an empty pass, which is how
+// this package's suites went unexercised by `go test` until now.
+func Test(t *testing.T) { chk.TestingT(t) }
+
+type versionMetadataTestSuite struct{}
+
+var _ = chk.Suite(&versionMetadataTestSuite{})
+
+// validManifest is a manifest for latest=99.99.99, min_supported=1.0.0, signed with the private
+// half of the test keypair whose public half is versionMetadataPublicKeyBase64. It was produced
+// offline with a throwaway keypair generated purely for this test file; it has no relationship to
+// any real AzCopy release key.
+const validManifest = `{"latest":"99.99.99","min_supported":"1.0.0","signature":"l1Y0Ssa/UUlvFtpgouvkkVdSyzofXoDAQ5bZqucrSnG4S5Ndd02DGs4cnDhMi0zWPJZQcJiS8caXxLltBapzCg=="}`
+
+func (s *versionMetadataTestSuite) TestParseAndVerifyVersionMetadata_Valid(c *chk.C) {
+	metadata, err := ParseAndVerifyVersionMetadata([]byte(validManifest))
+	c.Assert(err, chk.IsNil)
+	c.Assert(metadata.Latest, chk.Equals, "99.99.99")
+	c.Assert(metadata.MinSupported, chk.Equals, "1.0.0")
+}
+
+func (s *versionMetadataTestSuite) TestParseAndVerifyVersionMetadata_TamperedField(c *chk.C) {
+	// same signature as validManifest, but latest has been changed - the signature must no longer verify
+	tampered := `{"latest":"1.2.3","min_supported":"1.0.0","signature":"l1Y0Ssa/UUlvFtpgouvkkVdSyzofXoDAQ5bZqucrSnG4S5Ndd02DGs4cnDhMi0zWPJZQcJiS8caXxLltBapzCg=="}`
+	_, err := ParseAndVerifyVersionMetadata([]byte(tampered))
+	c.Assert(err, chk.NotNil)
+}
+
+func (s *versionMetadataTestSuite) TestParseAndVerifyVersionMetadata_MalformedJSON(c *chk.C) {
+	_, err := ParseAndVerifyVersionMetadata([]byte("not json"))
+	c.Assert(err, chk.NotNil)
+}
+
+func (s *versionMetadataTestSuite) TestParseAndVerifyVersionMetadata_MissingSignature(c *chk.C) {
+	_, err := ParseAndVerifyVersionMetadata([]byte(`{"latest":"99.99.99","min_supported":"1.0.0"}`))
+	c.Assert(err, chk.NotNil)
+}
+
+func (s *versionMetadataTestSuite) TestParseVersionMetadataOrLegacy_SignedJSON(c *chk.C) {
+	metadata, err := ParseVersionMetadataOrLegacy([]byte(validManifest))
+	c.Assert(err, chk.IsNil)
+	c.Assert(metadata.Latest, chk.Equals, "99.99.99")
+}
+
+func (s *versionMetadataTestSuite) TestParseVersionMetadataOrLegacy_LegacyPlainText(c *chk.C) {
+	// the legacy protocol: the first line of an arbitrary text blob is the latest version
+	metadata, err := ParseVersionMetadataOrLegacy([]byte("10.16.2\nsome other text on later lines\n"))
+	c.Assert(err, chk.IsNil)
+	c.Assert(metadata.Latest, chk.Equals, "10.16.2")
+}
+
+func (s *versionMetadataTestSuite) TestParseVersionMetadataOrLegacy_TamperedJSONIsNotAccepted(c *chk.C) {
+	// it looks like JSON, so the legacy fallback must not kick in - a tampered/invalid manifest
+	// should still be rejected, not silently downgraded to the unsigned protocol
+	tampered := `{"latest":"1.2.3","min_supported":"1.0.0","signature":"l1Y0Ssa/UUlvFtpgouvkkVdSyzofXoDAQ5bZqucrSnG4S5Ndd02DGs4cnDhMi0zWPJZQcJiS8caXxLltBapzCg=="}`
+	_, err := ParseVersionMetadataOrLegacy([]byte(tampered))
+	c.Assert(err, chk.NotNil)
+}
+
+func (s *versionMetadataTestSuite) TestHTTPSVersionMetadataSource_Valid(c *chk.C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(validManifest))
+	}))
+	defer server.Close()
+
+	source := NewHTTPSVersionMetadataSource(server.URL)
+	metadata, err := source.FetchVersionMetadata(context.Background())
+	c.Assert(err, chk.IsNil)
+	c.Assert(metadata.Latest, chk.Equals, "99.99.99")
+}
+
+func (s *versionMetadataTestSuite) TestHTTPSVersionMetadataSource_UnsignedResponseIsRejected(c *chk.C) {
+	// an HTTPS source never falls back to the legacy protocol, unlike the default blob source
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("10.16.2\n"))
+	}))
+	defer server.Close()
+
+	source := NewHTTPSVersionMetadataSource(server.URL)
+	_, err := source.FetchVersionMetadata(context.Background())
+	c.Assert(err, chk.NotNil)
+}
+
+func (s *versionMetadataTestSuite) TestHTTPSVersionMetadataSource_NonOKStatus(c *chk.C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	source := NewHTTPSVersionMetadataSource(server.URL)
+	_, err := source.FetchVersionMetadata(context.Background())
+	c.Assert(err, chk.NotNil)
+}
+
+func (s *versionMetadataTestSuite) TestFileVersionMetadataSource_Valid(c *chk.C) {
+	path := filepath.Join(c.MkDir(), "manifest.json")
+	c.Assert(os.WriteFile(path, []byte(validManifest), 0644), chk.IsNil)
+
+	source := NewFileVersionMetadataSource(path)
+	metadata, err := source.FetchVersionMetadata(context.Background())
+	c.Assert(err, chk.IsNil)
+	c.Assert(metadata.Latest, chk.Equals, "99.99.99")
+}
+
+func (s *versionMetadataTestSuite) TestFileVersionMetadataSource_MissingFile(c *chk.C) {
+	source := NewFileVersionMetadataSource(filepath.Join(c.MkDir(), "does-not-exist.json"))
+	_, err := source.FetchVersionMetadata(context.Background())
+	c.Assert(err, chk.NotNil)
+}
@@ -0,0 +1,255 @@
+// Copyright © 2017 Microsoft <wastore@microsoft.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package ste
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-storage-azcopy/common"
+)
+
+const (
+	// autoTuneSampleInterval is how often the auto-tuner re-samples aggregate throughput and
+	// reconsiders the worker count.
+	autoTuneSampleInterval = 10 * time.Second
+
+	// autoTuneStep is how much the auto-tuner increases concurrency by on a sample that shows
+	// healthy throughput growth.
+	autoTuneStep = 16
+
+	// autoTuneGrowthThreshold is the minimum throughput growth, between consecutive samples,
+	// that's considered worth climbing further for. Below this the tuner treats things as
+	// plateaued and backs off instead.
+	autoTuneGrowthThreshold = 0.05 // 5%
+)
+
+// ConcurrencyHintFileName is where NewConcurrencySettings and AutoTuner persist the converged
+// concurrency value inside a job's plan folder, so a later job that reuses the same plan folder
+// (see azcopyJobPlanFolder in cmd/root.go) starts its own auto-tuning from there instead of from
+// the CPU-derived default. It's a plain text file holding just the integer, not part of the actual
+// job-plan-header binary format.
+const ConcurrencyHintFileName = ".azcopy-concurrency-hint"
+
+// ConcurrencySettings controls how many goroutines the Scheduled Transfer Engine uses to move
+// data concurrently. InitialConcurrency is a CPU-derived (or user-capped, or persisted-hint-seeded)
+// starting point; when AutoTune is set, NewAutoTuner uses it as the seed for a throughput-based
+// hill-climb instead of running at a fixed value for the whole job.
+//
+// AutoTuner persists its converged value to jobPlanFolder (see ConcurrencyHintFileName) and, via
+// cmd/root.go, reports its starting point through the performance-advice channel (glcm.Info) when
+// providePerformanceAdvice is requested. What's still missing: MainSTE doesn't start Run or feed it
+// live throughput samples, because MainSTE has no aggregate bytes/sec counter in this codebase for
+// Run to sample from, and no worker pool for a mid-job Concurrency() change to actually resize.
+// Once that counter and pool exist, MainSTE (not this package) is the natural place to start Run.
+type ConcurrencySettings struct {
+	InitialConcurrency int
+	AutoTune           bool
+	MinConcurrency     int
+	MaxConcurrency     int
+}
+
+// NewConcurrencySettings picks a starting concurrency from the CPU count, capped to
+// maxFileAndSocketHandles when the OS imposes a tighter limit than the CPU-derived default would
+// need, and enables auto-tuning when the caller has asked for it (currently just azcopy bench; see
+// preferToAutoTuneGRs in cmd/root.go). When autoTune is set and jobPlanFolder already has a
+// concurrency hint persisted by a previous AutoTuner (see ConcurrencyHintFileName), that hint is
+// used as the starting point instead of the CPU-derived value, so a subsequent related job starts
+// near the optimum rather than re-climbing from scratch.
+func NewConcurrencySettings(maxFileAndSocketHandles int, autoTune bool, jobPlanFolder string) ConcurrencySettings {
+	initial := common.ComputeConcurrencyValue(runtime.NumCPU())
+	if autoTune {
+		if hint, ok := loadConcurrencyHint(jobPlanFolder); ok {
+			initial = hint
+		}
+	}
+	if maxFileAndSocketHandles > 0 && initial > maxFileAndSocketHandles {
+		initial = maxFileAndSocketHandles
+	}
+
+	return ConcurrencySettings{
+		InitialConcurrency: initial,
+		AutoTune:           autoTune,
+		MinConcurrency:     common.ConcurrencyMinValue,
+		MaxConcurrency:     common.ConcurrencyMaxValue,
+	}
+}
+
+// loadConcurrencyHint reads back a concurrency value previously persisted by an AutoTuner into
+// jobPlanFolder. Any problem reading or parsing it (folder not yet created, no prior hint, a
+// corrupt file) is treated the same as "no hint" rather than an error: falling back to the
+// CPU-derived default is always a safe, valid starting point.
+func loadConcurrencyHint(jobPlanFolder string) (int, bool) {
+	if jobPlanFolder == "" {
+		return 0, false
+	}
+
+	raw, err := ioutil.ReadFile(filepath.Join(jobPlanFolder, ConcurrencyHintFileName))
+	if err != nil {
+		return 0, false
+	}
+
+	value, err := strconv.Atoi(strings.TrimSpace(string(raw)))
+	if err != nil {
+		return 0, false
+	}
+
+	return value, true
+}
+
+// AutoTuner hill-climbs the number of concurrent goroutines based on measured aggregate
+// throughput: while throughput keeps growing by more than autoTuneGrowthThreshold between
+// samples, it keeps increasing concurrency by autoTuneStep; once growth plateaus or regresses, it
+// backs off by half of its last increment rather than the full step, so a single noisy sample
+// doesn't collapse the worker count back to where it started (hysteresis).
+//
+// Every adjustment persists the converged value to jobPlanFolder (see ConcurrencyHintFileName),
+// best-effort, so a subsequent related job can start near the optimum instead of re-climbing from
+// the CPU-derived default; NewConcurrencySettings is what reads it back.
+type AutoTuner struct {
+	mu sync.Mutex
+
+	min, max      int
+	jobPlanFolder string
+
+	current       int
+	lastIncrement int
+	lastSample    float64
+	haveSample    bool
+	reason        string
+}
+
+// NewAutoTuner seeds an AutoTuner from settings.InitialConcurrency, clamped to
+// settings.MinConcurrency/MaxConcurrency (which may be the package defaults or user-supplied
+// bounds; see common.ComputeConcurrencyValueWithBounds). jobPlanFolder is where it persists its
+// converged value as it adjusts (see ConcurrencyHintFileName); pass "" to disable persistence,
+// e.g. in tests.
+func NewAutoTuner(settings ConcurrencySettings, jobPlanFolder string) *AutoTuner {
+	return &AutoTuner{
+		min:           settings.MinConcurrency,
+		max:           settings.MaxConcurrency,
+		jobPlanFolder: jobPlanFolder,
+		current:       clampConcurrency(settings.InitialConcurrency, settings.MinConcurrency, settings.MaxConcurrency),
+		reason:        "starting from the initial concurrency (CPU-derived, or a hint persisted by a previous job); no throughput sample yet",
+	}
+}
+
+// Concurrency returns the tuner's current recommendation.
+func (t *AutoTuner) Concurrency() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.current
+}
+
+// Reason returns a short, human-readable explanation of the tuner's last decision. cmd/root.go
+// surfaces it alongside Concurrency() through the performance-advice channel when
+// providePerformanceAdvice is true; since MainSTE doesn't start Run (see ConcurrencySettings's doc
+// comment), that is only ever the starting decision.
+func (t *AutoTuner) Reason() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.reason
+}
+
+// OnThroughputSample feeds a fresh aggregate-throughput measurement (bytes/sec) to the tuner and
+// returns the (possibly adjusted) concurrency to use going forward.
+func (t *AutoTuner) OnThroughputSample(bytesPerSecond float64) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.haveSample {
+		// no baseline yet: take one step up so the next sample has something to compare against
+		t.haveSample = true
+		t.lastSample = bytesPerSecond
+		t.lastIncrement = autoTuneStep
+		t.current = clampConcurrency(t.current+autoTuneStep, t.min, t.max)
+		t.reason = "warming up: no throughput baseline yet, probing upward"
+		t.persistHintLocked()
+		return t.current
+	}
+
+	growth := 0.0
+	if t.lastSample > 0 {
+		growth = (bytesPerSecond - t.lastSample) / t.lastSample
+	}
+
+	if growth > autoTuneGrowthThreshold {
+		t.lastIncrement = autoTuneStep
+		t.current = clampConcurrency(t.current+autoTuneStep, t.min, t.max)
+		t.reason = fmt.Sprintf("throughput grew %.1f%% since the last sample, increasing concurrency to %d", growth*100, t.current)
+	} else {
+		backoff := t.lastIncrement / 2
+		t.lastIncrement = backoff
+		t.current = clampConcurrency(t.current-backoff, t.min, t.max)
+		t.reason = fmt.Sprintf("throughput grew only %.1f%% since the last sample, backing off to %d", growth*100, t.current)
+	}
+
+	t.lastSample = bytesPerSecond
+	t.persistHintLocked()
+	return t.current
+}
+
+// persistHintLocked writes the current concurrency to jobPlanFolder as the hint
+// NewConcurrencySettings reads back for a later job. Called with t.mu already held. A write
+// failure (e.g. the plan folder doesn't exist yet) is silently ignored: the hint is purely an
+// optimization for the next job, and the current one must not fail because of it.
+func (t *AutoTuner) persistHintLocked() {
+	if t.jobPlanFolder == "" {
+		return
+	}
+	_ = ioutil.WriteFile(filepath.Join(t.jobPlanFolder, ConcurrencyHintFileName), []byte(strconv.Itoa(t.current)), 0644)
+}
+
+func clampConcurrency(value, min, max int) int {
+	if value < min {
+		return min
+	}
+	if value > max {
+		return max
+	}
+	return value
+}
+
+// Run samples aggregate throughput (via sampleThroughput) every autoTuneSampleInterval and feeds
+// it to OnThroughputSample, until ctx is done. Once MainSTE is wired up to call it (see
+// ConcurrencySettings's doc comment), it's expected to start this in the background
+// when settings.AutoTune is set, with sampleThroughput reading the job's real aggregate
+// throughput counters.
+func (t *AutoTuner) Run(ctx context.Context, sampleThroughput func() float64) {
+	ticker := time.NewTicker(autoTuneSampleInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			t.OnThroughputSample(sampleThroughput())
+		}
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright © 2017 Microsoft <wastore@microsoft.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package ste
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	chk "gopkg.in/check.v1"
+
+	"github.com/Azure/azure-storage-azcopy/common"
+)
+
+func Test(t *testing.T) { chk.TestingT(t) }
+
+type concurrencyTestSuite struct{}
+
+var _ = chk.Suite(&concurrencyTestSuite{})
+
+func (s *concurrencyTestSuite) TestAutoTunerClimbsWhileThroughputGrows(c *chk.C) {
+	settings := ConcurrencySettings{InitialConcurrency: 32, MinConcurrency: 32, MaxConcurrency: 300}
+	tuner := NewAutoTuner(settings, "")
+
+	throughput := 100.0
+	for i := 0; i < 4; i++ {
+		throughput *= 1.2 // 20% growth each sample, comfortably over the 5% threshold
+		tuner.OnThroughputSample(throughput)
+	}
+
+	c.Assert(tuner.Concurrency() > settings.InitialConcurrency, chk.Equals, true)
+	c.Assert(tuner.Concurrency() <= settings.MaxConcurrency, chk.Equals, true)
+}
+
+func (s *concurrencyTestSuite) TestAutoTunerBacksOffOnPlateau(c *chk.C) {
+	settings := ConcurrencySettings{InitialConcurrency: 32, MinConcurrency: 32, MaxConcurrency: 300}
+	tuner := NewAutoTuner(settings, "")
+
+	throughput := 100.0
+	for i := 0; i < 4; i++ {
+		throughput *= 1.2
+		tuner.OnThroughputSample(throughput)
+	}
+	peak := tuner.Concurrency()
+
+	// throughput plateaus: the tuner should back off, but only by half its last increment
+	// (hysteresis), not collapse all the way back down to the starting concurrency
+	tuner.OnThroughputSample(throughput)
+
+	c.Assert(tuner.Concurrency() < peak, chk.Equals, true)
+	c.Assert(tuner.Concurrency() > settings.InitialConcurrency, chk.Equals, true)
+}
+
+func (s *concurrencyTestSuite) TestAutoTunerClampsToBounds(c *chk.C) {
+	settings := ConcurrencySettings{InitialConcurrency: 290, MinConcurrency: 32, MaxConcurrency: 300}
+	tuner := NewAutoTuner(settings, "")
+
+	throughput := 100.0
+	for i := 0; i < 10; i++ {
+		throughput *= 2 // keep throughput growing so the tuner keeps trying to climb
+		tuner.OnThroughputSample(throughput)
+	}
+
+	c.Assert(tuner.Concurrency(), chk.Equals, settings.MaxConcurrency)
+}
+
+func (s *concurrencyTestSuite) TestAutoTunerPersistsConcurrencyHint(c *chk.C) {
+	jobPlanFolder := c.MkDir()
+	settings := ConcurrencySettings{InitialConcurrency: 32, MinConcurrency: 32, MaxConcurrency: 300}
+	tuner := NewAutoTuner(settings, jobPlanFolder)
+
+	tuner.OnThroughputSample(100)
+
+	raw, err := ioutil.ReadFile(filepath.Join(jobPlanFolder, ConcurrencyHintFileName))
+	c.Assert(err, chk.IsNil)
+	c.Assert(string(raw), chk.Equals, "48") // 32 + autoTuneStep(16) on the warm-up sample
+}
+
+func (s *concurrencyTestSuite) TestNewConcurrencySettingsSeedsFromPersistedHint(c *chk.C) {
+	jobPlanFolder := c.MkDir()
+	c.Assert(ioutil.WriteFile(filepath.Join(jobPlanFolder, ConcurrencyHintFileName), []byte("123"), 0644), chk.IsNil)
+
+	settings := NewConcurrencySettings(0, true, jobPlanFolder)
+	c.Assert(settings.InitialConcurrency, chk.Equals, 123)
+}
+
+func (s *concurrencyTestSuite) TestNewConcurrencySettingsIgnoresHintWhenAutoTuneIsOff(c *chk.C) {
+	jobPlanFolder := c.MkDir()
+	c.Assert(ioutil.WriteFile(filepath.Join(jobPlanFolder, ConcurrencyHintFileName), []byte("123"), 0644), chk.IsNil)
+
+	settings := NewConcurrencySettings(0, false, jobPlanFolder)
+	c.Assert(settings.InitialConcurrency, chk.Equals, common.ComputeConcurrencyValue(runtime.NumCPU()))
+}
+
+func (s *concurrencyTestSuite) TestNewConcurrencySettingsFallsBackWithoutHint(c *chk.C) {
+	settings := NewConcurrencySettings(0, true, c.MkDir())
+	c.Assert(settings.InitialConcurrency, chk.Equals, common.ComputeConcurrencyValue(runtime.NumCPU()))
+}